@@ -0,0 +1,76 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// clienter is the minimal HTTP transport that Fleet API commands rely on to
+// talk to the Fleet server.
+type clienter interface {
+	Send(
+		method string,
+		path string,
+		params url.Values,
+		headers http.Header,
+		body io.Reader,
+	) (*http.Response, error)
+}
+
+// contextSender is an optional capability of a clienter that can honor a
+// context directly, typically via http.NewRequestWithContext on its
+// transport. Clients that don't implement it fall back to the goroutine
+// shim in sendWithContext.
+type contextSender interface {
+	SendWithContext(
+		ctx context.Context,
+		method string,
+		path string,
+		params url.Values,
+		headers http.Header,
+		body io.Reader,
+	) (*http.Response, error)
+}
+
+// sendWithContext sends a request through client, honoring ctx. When client
+// implements contextSender, the call is delegated directly so cancellation
+// reaches the in-flight HTTP request. Otherwise client.Send is run in a
+// goroutine and sendWithContext returns as soon as ctx is done, without
+// waiting for the (now abandoned) Send call to return.
+func sendWithContext(
+	ctx context.Context,
+	client clienter,
+	method string,
+	path string,
+	params url.Values,
+	headers http.Header,
+	body io.Reader,
+) (*http.Response, error) {
+	if cs, ok := client.(contextSender); ok {
+		return cs.SendWithContext(ctx, method, path, params, headers, body)
+	}
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.Send(method, path, params, headers, body)
+		done <- result{resp: resp, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.resp, r.err
+	}
+}
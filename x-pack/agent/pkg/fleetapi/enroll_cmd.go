@@ -6,7 +6,10 @@ package fleetapi
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -21,10 +24,20 @@ type EnrollType string
 const (
 	// PermanentEnroll is default enrollment type, by default an Agent is permanently enroll to Agent.
 	PermanentEnroll = EnrollType("PERMANENT")
+
+	// EphemeralEnroll discards the agent's identity at shutdown: no access
+	// token is persisted and the agent must re-enroll on its next start.
+	EphemeralEnroll = EnrollType("EPHEMERAL")
+
+	// TemporaryEnroll is valid until the expiry timestamp Fleet returns in
+	// EnrollItemResponse.ExpiresAt.
+	TemporaryEnroll = EnrollType("TEMPORARY")
 )
 
 var mapEnrollType = map[string]EnrollType{
 	"PERMANENT": PermanentEnroll,
+	"EPHEMERAL": EphemeralEnroll,
+	"TEMPORARY": TemporaryEnroll,
 }
 
 var reverseMapEnrollType = make(map[EnrollType]string)
@@ -44,7 +57,7 @@ func (p *EnrollType) UnmarshalJSON(b []byte) error {
 	s = s[1 : len(s)-1]
 	v, ok := mapEnrollType[s]
 	if !ok {
-		return fmt.Errorf("value of '%s' is an invalid enrollment type, supported type is 'PERMANENT'", s)
+		return fmt.Errorf("value of '%s' is an invalid enrollment type, supported types are 'PERMANENT', 'EPHEMERAL', 'TEMPORARY'", s)
 	}
 
 	*p = v
@@ -78,6 +91,39 @@ type EnrollRequest struct {
 	Type            EnrollType `json:"type"`
 	SharedID        string     `json:"sharedId,omitempty"`
 	Metadata        Metadata   `json:"metadata"`
+
+	// ExpiresIn is only meaningful for TemporaryEnroll requests: it asks
+	// Fleet to expire the agent's identity after the given duration. It is
+	// serialized as expires_in seconds and omitted otherwise.
+	ExpiresIn time.Duration `json:"-"`
+
+	// RequireAPIKey makes EnrollCmd validate Fleet's response with
+	// EnrollResponse.ValidateRequireAPIKey instead of Validate, failing when
+	// the response doesn't include an Elasticsearch API key.
+	RequireAPIKey bool `json:"-"`
+
+	// ClientCertificate, when set, is installed on the underlying clienter's
+	// transport for the duration of the call, enrolling via mTLS instead of
+	// (or alongside) the kbn-fleet-enrollment-token header.
+	ClientCertificate *tls.Certificate `json:"-"`
+}
+
+// MarshalJSON marshals the enrollment request, serializing ExpiresIn, when
+// set, as the wire's expires_in seconds field.
+func (e EnrollRequest) MarshalJSON() ([]byte, error) {
+	type enrollRequest EnrollRequest
+
+	out := struct {
+		enrollRequest
+		ExpiresIn *float64 `json:"expires_in,omitempty"`
+	}{enrollRequest: enrollRequest(e)}
+
+	if e.ExpiresIn > 0 {
+		secs := e.ExpiresIn.Seconds()
+		out.ExpiresIn = &secs
+	}
+
+	return json.Marshal(out)
 }
 
 // Metadata is a all the metadata send or received from the agent.
@@ -90,7 +136,7 @@ type Metadata struct {
 func (e *EnrollRequest) Validate() error {
 	var err error
 
-	if len(e.EnrollmentToken) == 0 {
+	if len(e.EnrollmentToken) == 0 && e.ClientCertificate == nil {
 		err = multierror.Append(err, errors.New("missing enrollment token"))
 	}
 
@@ -136,6 +182,32 @@ type EnrollItemResponse struct {
 	LocalMetadata        map[string]interface{} `json:"local_metadata"`
 	Actions              []interface{}          `json:"actions"`
 	AccessToken          string                 `json:"access_token"`
+
+	// ExpiresAt is set by Fleet for TemporaryEnroll items; nil for
+	// enrollments that don't expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// APIKey is the Elasticsearch API key provisioned alongside the access
+	// token. Older Fleet servers don't return it, so it's nil on legacy
+	// responses.
+	APIKey *APIKey `json:"api_key,omitempty"`
+}
+
+// APIKey is the Elasticsearch API key a shipper uses to push data,
+// provisioned alongside the agent's access token. It has its own lifecycle
+// and invalidation path, independent of the access token.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// IsExpired reports whether the enrollment had already expired as of now.
+// Enrollments without an ExpiresAt (PermanentEnroll, EphemeralEnroll) never
+// expire.
+func (e EnrollItemResponse) IsExpired(now time.Time) bool {
+	return e.ExpiresAt != nil && !e.ExpiresAt.After(now)
 }
 
 // Validate validates the response send from the server.
@@ -150,8 +222,35 @@ func (e *EnrollResponse) Validate() error {
 		err = multierror.Append(err, errors.New("missing enrollment type"))
 	}
 
-	if len(e.Item.AccessToken) == 0 {
-		err = multierror.Append(err, errors.New("access token is missing"))
+	switch e.Item.Type {
+	case EphemeralEnroll:
+		if len(e.Item.AccessToken) != 0 {
+			err = multierror.Append(err, errors.New("ephemeral enrollment must not persist an access token"))
+		}
+	case TemporaryEnroll:
+		if e.Item.ExpiresAt == nil {
+			err = multierror.Append(err, errors.New("temporary enrollment is missing an expiry"))
+		}
+		if len(e.Item.AccessToken) == 0 {
+			err = multierror.Append(err, errors.New("access token is missing"))
+		}
+	default:
+		if len(e.Item.AccessToken) == 0 {
+			err = multierror.Append(err, errors.New("access token is missing"))
+		}
+	}
+
+	return err
+}
+
+// ValidateRequireAPIKey validates the response the same way Validate does,
+// additionally treating a missing Elasticsearch API key as invalid. Use this
+// instead of Validate when the request had RequireAPIKey set.
+func (e *EnrollResponse) ValidateRequireAPIKey() error {
+	err := e.Validate()
+
+	if e.Item.APIKey == nil || len(e.Item.APIKey.Key) == 0 {
+		err = multierror.Append(err, errors.New("api key is missing"))
 	}
 
 	return err
@@ -160,13 +259,79 @@ func (e *EnrollResponse) Validate() error {
 // EnrollCmd is the command to be executed to enroll an agent into Fleet.
 type EnrollCmd struct {
 	client clienter
+	retry  *RetryPolicy
 }
 
 // Execute enroll the Agent in the Fleet.
 func (e *EnrollCmd) Execute(r *EnrollRequest) (*EnrollResponse, error) {
+	return e.ExecuteContext(context.Background(), r)
+}
+
+// ExecuteContext enrolls the Agent in the Fleet, honoring ctx cancellation and
+// deadlines at every phase: request marshaling, wire transmission, response
+// decoding and validation. When the EnrollCmd was created with
+// NewEnrollCmdWithRetry, retryable failures are retried according to the
+// configured RetryPolicy.
+func (e *EnrollCmd) ExecuteContext(ctx context.Context, r *EnrollRequest) (*EnrollResponse, error) {
+	if e.retry == nil {
+		return e.executeOnce(ctx, r)
+	}
+	return e.executeWithRetry(ctx, r)
+}
+
+// executeWithRetry runs executeOnce in a loop, retrying retryable failures
+// according to e.retry until it succeeds, a non-retryable error is hit, the
+// attempt budget is exhausted, or ctx is done. The returned error wraps every
+// attempt's cause so operators can tell whether enrollment ultimately failed
+// due to auth vs. transport.
+func (e *EnrollCmd) executeWithRetry(ctx context.Context, r *EnrollRequest) (*EnrollResponse, error) {
+	policy := *e.retry
+
+	var attempts error
+	for attempt := 1; ; attempt++ {
+		resp, err := e.executeOnce(ctx, r)
+		if err == nil {
+			return resp, nil
+		}
+
+		attempts = multierror.Append(attempts, errors.Wrapf(err, "attempt %d", attempt))
+
+		if !isRetryable(err) || attempt >= policy.MaxAttempts {
+			return nil, attempts
+		}
+
+		wait := nextBackoff(policy, attempt)
+		var statusErr *StatusError
+		if stderrors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+			if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+				wait = policy.MaxBackoff
+			}
+		}
+
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, multierror.Append(attempts, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// executeOnce performs a single enrollment attempt.
+func (e *EnrollCmd) executeOnce(ctx context.Context, r *EnrollRequest) (*EnrollResponse, error) {
 	const p = "/api/fleet/agents/enroll"
 	const key = "kbn-fleet-enrollment-token"
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if err := r.Validate(); err != nil {
 		return nil, err
 	}
@@ -180,14 +345,36 @@ func (e *EnrollCmd) Execute(r *EnrollRequest) (*EnrollResponse, error) {
 		return nil, errors.Wrap(err, "fail to encode the enrollment request")
 	}
 
-	resp, err := e.client.Send("POST", p, nil, headers, bytes.NewBuffer(b))
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+
+	if r.ClientCertificate != nil {
+		installer, ok := e.client.(clientCertInstaller)
+		if !ok {
+			return nil, errors.New("client does not support installing a client certificate")
+		}
+
+		restore := installer.SetClientCertificate(r.ClientCertificate)
+		defer restore()
+	}
+
+	resp, err := sendWithContext(ctx, e.client, "POST", p, nil, headers, bytes.NewBuffer(b))
+	if err != nil {
+		return nil, &transportError{Err: err}
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, extract(resp.Body)
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Err: extract(resp.Body)}
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			statusErr.RetryAfter = d
+		}
+		return nil, statusErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	enrollResponse := &EnrollResponse{}
@@ -196,14 +383,76 @@ func (e *EnrollCmd) Execute(r *EnrollRequest) (*EnrollResponse, error) {
 		return nil, errors.Wrap(err, "fail to decode enrollment response")
 	}
 
-	if err := enrollResponse.Validate(); err != nil {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if r.RequireAPIKey {
+		err = enrollResponse.ValidateRequireAPIKey()
+	} else {
+		err = enrollResponse.Validate()
+	}
+	if err != nil {
 		return nil, err
 	}
 
 	return enrollResponse, nil
 }
 
+// InvalidateAPIKey invalidates the Elasticsearch API key identified by id,
+// authenticating with accessToken. EnrollCmd is deliberately stateless and
+// doesn't retain the access token from a prior Execute/ExecuteContext call,
+// since a single EnrollCmd can be reused to enroll (and later invalidate keys
+// for) more than one agent identity; callers are expected to persist the
+// access token returned in EnrollItemResponse themselves and pass it back in
+// here. This lets agents rotate their API key without going through
+// re-enrollment.
+func (e *EnrollCmd) InvalidateAPIKey(ctx context.Context, accessToken, id string) error {
+	const p = "/api/fleet/agents/api_keys/invalidate"
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(accessToken) == 0 {
+		return errors.New("missing access token")
+	}
+
+	if len(id) == 0 {
+		return errors.New("missing api key id")
+	}
+
+	b, err := json.Marshal(struct {
+		ID string `json:"id"`
+	}{ID: id})
+	if err != nil {
+		return errors.Wrap(err, "fail to encode the invalidate api key request")
+	}
+
+	headers := map[string][]string{
+		"Authorization": {"Bearer " + accessToken},
+	}
+
+	resp, err := sendWithContext(ctx, e.client, "POST", p, nil, headers, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode, Err: extract(resp.Body)}
+	}
+
+	return nil
+}
+
 // NewEnrollCmd creates a new EnrollCmd.
 func NewEnrollCmd(client clienter) *EnrollCmd {
 	return &EnrollCmd{client: client}
-}
\ No newline at end of file
+}
+
+// NewEnrollCmdWithRetry creates a new EnrollCmd that retries failed
+// enrollment attempts according to policy.
+func NewEnrollCmdWithRetry(client clienter, policy RetryPolicy) *EnrollCmd {
+	return &EnrollCmd{client: client, retry: &policy}
+}
@@ -0,0 +1,160 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// legacyEnrollResponseFixture mirrors a pre-api_key Fleet server response.
+const legacyEnrollResponseFixture = `{
+	"action": "created",
+	"success": true,
+	"item": {
+		"id": "a4937110-e53e-11e9-934f-47a8e38a522c",
+		"active": true,
+		"policy_id": "default",
+		"type": "PERMANENT",
+		"enrolled_at": "2019-10-02T18:01:22.337Z",
+		"user_provided_metadata": {},
+		"local_metadata": {},
+		"actions": [],
+		"access_token": "ACCESS_TOKEN"
+	}
+}`
+
+// enrollResponseWithAPIKeyFixture mirrors a Fleet server that also provisions
+// an Elasticsearch API key.
+const enrollResponseWithAPIKeyFixture = `{
+	"action": "created",
+	"success": true,
+	"item": {
+		"id": "a4937110-e53e-11e9-934f-47a8e38a522c",
+		"active": true,
+		"policy_id": "default",
+		"type": "PERMANENT",
+		"enrolled_at": "2019-10-02T18:01:22.337Z",
+		"user_provided_metadata": {},
+		"local_metadata": {},
+		"actions": [],
+		"access_token": "ACCESS_TOKEN",
+		"api_key": {
+			"id": "key-id",
+			"key": "key-value",
+			"name": "default",
+			"expires_at": "2020-10-02T18:01:22.337Z"
+		}
+	}
+}`
+
+func TestEnrollResponseRoundTrip_Legacy(t *testing.T) {
+	var resp EnrollResponse
+	if err := json.Unmarshal([]byte(legacyEnrollResponseFixture), &resp); err != nil {
+		t.Fatalf("unmarshal legacy fixture: %v", err)
+	}
+
+	if resp.Item.APIKey != nil {
+		t.Fatalf("expected no api key on a legacy response, got %+v", resp.Item.APIKey)
+	}
+
+	if err := resp.Validate(); err != nil {
+		t.Fatalf("legacy response should validate without requiring an api key: %v", err)
+	}
+
+	if err := resp.ValidateRequireAPIKey(); err == nil {
+		t.Fatal("expected validation to fail when an api key is required but absent")
+	}
+
+	b, err := json.Marshal(&resp)
+	if err != nil {
+		t.Fatalf("marshal legacy response: %v", err)
+	}
+
+	var roundTripped EnrollResponse
+	if err := json.Unmarshal(b, &roundTripped); err != nil {
+		t.Fatalf("unmarshal round-tripped legacy response: %v", err)
+	}
+
+	if roundTripped.Item.AccessToken != resp.Item.AccessToken {
+		t.Fatalf("access token did not round-trip: got %q, want %q", roundTripped.Item.AccessToken, resp.Item.AccessToken)
+	}
+	if roundTripped.Item.APIKey != nil {
+		t.Fatalf("expected api key to stay absent after round-trip, got %+v", roundTripped.Item.APIKey)
+	}
+}
+
+func TestEnrollResponseRoundTrip_WithAPIKey(t *testing.T) {
+	var resp EnrollResponse
+	if err := json.Unmarshal([]byte(enrollResponseWithAPIKeyFixture), &resp); err != nil {
+		t.Fatalf("unmarshal fixture: %v", err)
+	}
+
+	if resp.Item.APIKey == nil {
+		t.Fatal("expected an api key on the new response shape")
+	}
+	if resp.Item.APIKey.Key != "key-value" {
+		t.Fatalf("unexpected api key value: %q", resp.Item.APIKey.Key)
+	}
+
+	if err := resp.ValidateRequireAPIKey(); err != nil {
+		t.Fatalf("response with an api key should satisfy RequireAPIKey: %v", err)
+	}
+
+	b, err := json.Marshal(&resp)
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+
+	var roundTripped EnrollResponse
+	if err := json.Unmarshal(b, &roundTripped); err != nil {
+		t.Fatalf("unmarshal round-tripped response: %v", err)
+	}
+
+	if roundTripped.Item.APIKey == nil || roundTripped.Item.APIKey.ID != resp.Item.APIKey.ID {
+		t.Fatalf("api key did not round-trip: got %+v, want %+v", roundTripped.Item.APIKey, resp.Item.APIKey)
+	}
+}
+
+func TestEnrollRequestMarshalJSON_ExpiresIn(t *testing.T) {
+	r := &EnrollRequest{
+		EnrollmentToken: "token",
+		Type:            TemporaryEnroll,
+		ExpiresIn:       90 * time.Second,
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if decoded["expires_in"] != float64(90) {
+		t.Fatalf("expected expires_in to be 90, got %v", decoded["expires_in"])
+	}
+}
+
+func TestEnrollRequestMarshalJSON_NoExpiresIn(t *testing.T) {
+	r := &EnrollRequest{EnrollmentToken: "token", Type: PermanentEnroll}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if _, ok := decoded["expires_in"]; ok {
+		t.Fatal("expected expires_in to be omitted when unset")
+	}
+}
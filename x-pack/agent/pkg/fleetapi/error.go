@@ -0,0 +1,84 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidResponse is returned when the Fleet server answers with an error
+// response whose body is empty.
+var ErrInvalidResponse = errors.New("fail to enroll: invalid response from fleet server")
+
+// apiError is the shape of the error body returned by the Fleet server.
+type apiError struct {
+	StatusCode int    `json:"statusCode"`
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+}
+
+// StatusError wraps a non-200 response from the Fleet server with the HTTP
+// status code and any Retry-After duration it carried, so callers like the
+// retry policy in EnrollCmd can make retry decisions without re-parsing the
+// response.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap allows errors.As/errors.Is to see through StatusError to the
+// underlying cause.
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// transportError marks an error as having occurred before a response was
+// ever received from Fleet (connection refused, DNS failure, a timeout, ctx
+// cancellation mid-flight...), as opposed to a validation, encoding or
+// decoding error, which is deterministic and will fail the same way on every
+// attempt. Only transportError and StatusError are considered retryable by
+// isRetryable.
+type transportError struct {
+	Err error
+}
+
+func (e *transportError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap allows errors.As/errors.Is to see through transportError to the
+// underlying cause.
+func (e *transportError) Unwrap() error {
+	return e.Err
+}
+
+// extract reads an error response body from the Fleet server and turns it into
+// a Go error, falling back to the raw body when it isn't valid JSON.
+func extract(reader io.Reader) error {
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "fail to read the response body")
+	}
+
+	var apiErr apiError
+	if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Message == "" {
+		if len(body) == 0 {
+			return ErrInvalidResponse
+		}
+		return errors.Errorf("fail to enroll: %s", string(body))
+	}
+
+	return errors.Errorf("fail to enroll: %s", apiErr.Message)
+}
@@ -0,0 +1,136 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MetadataProvider collects a namespaced slice of metadata (e.g. keys under
+// "os.*" or "host.*") to merge into EnrollRequest.Metadata.Local.
+//
+// Collect must return once ctx is done: MetadataRegistry.Collect waits for
+// every provider to return, so its Timeout bounds only providers that honor
+// ctx.
+type MetadataProvider interface {
+	Collect(ctx context.Context) (map[string]interface{}, error)
+}
+
+// MetadataProviderFunc adapts a plain function to a MetadataProvider.
+type MetadataProviderFunc func(ctx context.Context) (map[string]interface{}, error)
+
+// Collect calls f.
+func (f MetadataProviderFunc) Collect(ctx context.Context) (map[string]interface{}, error) {
+	return f(ctx)
+}
+
+// namedMetadataProvider is implemented by providers that want a stable,
+// human-readable name recorded in meta.sources. Providers that don't
+// implement it are recorded under their Go type name instead.
+type namedMetadataProvider interface {
+	Name() string
+}
+
+// MetadataRegistry runs a set of MetadataProviders concurrently and merges
+// their results into a single map suitable for EnrollRequest.Metadata.Local.
+type MetadataRegistry struct {
+	Providers []MetadataProvider
+
+	// Timeout bounds each individual provider; providers that exceed it are
+	// dropped. Zero means no per-provider timeout beyond ctx itself.
+	Timeout time.Duration
+}
+
+// NewMetadataRegistry creates a MetadataRegistry with the given providers and
+// a conservative default per-provider timeout.
+func NewMetadataRegistry(providers ...MetadataProvider) *MetadataRegistry {
+	return &MetadataRegistry{Providers: providers, Timeout: 2 * time.Second}
+}
+
+// Collect runs every provider concurrently, bounded by the registry's
+// Timeout, and merges their results with deterministic key namespacing.
+// Providers that error out or exceed their budget are dropped; the names of
+// providers that did contribute are recorded under "meta.sources".
+func (m *MetadataRegistry) Collect(ctx context.Context) map[string]interface{} {
+	type contribution struct {
+		name string
+		data map[string]interface{}
+	}
+
+	contributions := make(chan contribution, len(m.Providers))
+
+	var wg sync.WaitGroup
+	for _, p := range m.Providers {
+		p := p
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			providerCtx := ctx
+			if m.Timeout > 0 {
+				var cancel context.CancelFunc
+				providerCtx, cancel = context.WithTimeout(ctx, m.Timeout)
+				defer cancel()
+			}
+
+			data, err := p.Collect(providerCtx)
+			if err != nil || len(data) == 0 || providerCtx.Err() != nil {
+				return
+			}
+
+			contributions <- contribution{name: providerName(p), data: data}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(contributions)
+	}()
+
+	merged := make(map[string]interface{})
+	var sources []string
+	for c := range contributions {
+		for k, v := range c.data {
+			merged[k] = v
+		}
+		sources = append(sources, c.name)
+	}
+
+	if len(sources) > 0 {
+		sort.Strings(sources)
+		merged["meta.sources"] = sources
+	}
+
+	return merged
+}
+
+func providerName(p MetadataProvider) string {
+	if n, ok := p.(namedMetadataProvider); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", p)
+}
+
+// ExecuteWithProviders enrolls the Agent in the Fleet after collecting
+// r.Metadata.Local from providers, running them concurrently with a bounded
+// timeout per provider. Results are merged on top of whatever the caller
+// already set on r.Metadata.Local.
+func (e *EnrollCmd) ExecuteWithProviders(ctx context.Context, r *EnrollRequest, providers ...MetadataProvider) (*EnrollResponse, error) {
+	collected := NewMetadataRegistry(providers...).Collect(ctx)
+
+	if r.Metadata.Local == nil {
+		r.Metadata.Local = make(map[string]interface{}, len(collected))
+	}
+	for k, v := range collected {
+		r.Metadata.Local[k] = v
+	}
+
+	return e.ExecuteContext(ctx, r)
+}
@@ -0,0 +1,220 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// OSMetadataProvider collects OS and kernel details under the "os.*"
+// namespace.
+type OSMetadataProvider struct{}
+
+// Name identifies this provider in meta.sources.
+func (OSMetadataProvider) Name() string { return "os" }
+
+// Collect gathers runtime.GOOS/GOARCH and, on Linux, the kernel version.
+func (OSMetadataProvider) Collect(ctx context.Context) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"os.platform": runtime.GOOS,
+		"os.arch":     runtime.GOARCH,
+	}
+
+	if runtime.GOOS == "linux" {
+		if b, err := ioutil.ReadFile("/proc/version"); err == nil {
+			data["os.kernel"] = strings.TrimSpace(string(b))
+		}
+	}
+
+	return data, nil
+}
+
+// HostMetadataProvider collects host identity details under the "host.*"
+// namespace.
+type HostMetadataProvider struct{}
+
+// Name identifies this provider in meta.sources.
+func (HostMetadataProvider) Name() string { return "host" }
+
+// Collect gathers the hostname, architecture, and the MAC address of the
+// first non-loopback network interface.
+func (HostMetadataProvider) Collect(ctx context.Context) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"host.arch": runtime.GOARCH,
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		data["host.hostname"] = hostname
+	}
+
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+				continue
+			}
+			data["host.mac"] = iface.HardwareAddr.String()
+			break
+		}
+	}
+
+	return data, nil
+}
+
+// cloudIMDSEndpoint describes a single cloud metadata service to probe.
+type cloudIMDSEndpoint struct {
+	provider string
+	url      string
+	headers  map[string]string
+}
+
+var cloudIMDSEndpoints = []cloudIMDSEndpoint{
+	{
+		provider: "aws",
+		url:      "http://169.254.169.254/latest/meta-data/instance-id",
+	},
+	{
+		provider: "gcp",
+		url:      "http://169.254.169.254/computeMetadata/v1/instance/id",
+		headers:  map[string]string{"Metadata-Flavor": "Google"},
+	},
+	{
+		provider: "azure",
+		url:      "http://169.254.169.254/metadata/instance/compute/vmId?api-version=2021-02-01&format=text",
+		headers:  map[string]string{"Metadata": "true"},
+	},
+}
+
+// CloudMetadataProvider detects which cloud, if any, the agent is running on
+// by probing each provider's instance metadata service (IMDS) with a short
+// timeout, falling back to reporting nothing when none answer.
+type CloudMetadataProvider struct {
+	// Timeout bounds each individual IMDS probe. Defaults to 300ms.
+	Timeout time.Duration
+}
+
+// Name identifies this provider in meta.sources.
+func (CloudMetadataProvider) Name() string { return "cloud" }
+
+// Collect probes every known cloud IMDS endpoint concurrently and reports the
+// first one that answers.
+func (p CloudMetadataProvider) Collect(ctx context.Context) (map[string]interface{}, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 300 * time.Millisecond
+	}
+
+	client := &http.Client{Timeout: timeout}
+
+	type probe struct {
+		provider string
+		id       string
+	}
+	results := make(chan probe, len(cloudIMDSEndpoints))
+
+	for _, ep := range cloudIMDSEndpoints {
+		ep := ep
+		go func() {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.url, nil)
+			if err != nil {
+				return
+			}
+			for k, v := range ep.headers {
+				req.Header.Set(k, v)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil || len(body) == 0 {
+				return
+			}
+
+			results <- probe{provider: ep.provider, id: strings.TrimSpace(string(body))}
+		}()
+	}
+
+	select {
+	case r := <-results:
+		return map[string]interface{}{
+			"cloud.provider":    r.provider,
+			"cloud.instance_id": r.id,
+		}, nil
+	case <-time.After(timeout):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ContainerMetadataProvider collects container and Kubernetes context under
+// the "container.*" and "kubernetes.*" namespaces, via cgroup inspection and
+// the Kubernetes downward API's environment variables.
+type ContainerMetadataProvider struct{}
+
+// Name identifies this provider in meta.sources.
+func (ContainerMetadataProvider) Name() string { return "container" }
+
+// Collect detects whether the process is running inside a container and, if
+// so, whether that container is Kubernetes-managed.
+func (ContainerMetadataProvider) Collect(ctx context.Context) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		data["container.runtime"] = "docker"
+	}
+
+	if b, err := ioutil.ReadFile("/proc/self/cgroup"); err == nil {
+		cgroup := string(b)
+		switch {
+		case strings.Contains(cgroup, "kubepods"):
+			data["container.orchestrator"] = "kubernetes"
+		case strings.Contains(cgroup, "docker"):
+			data["container.runtime"] = "docker"
+		}
+	}
+
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		data["kubernetes.pod.name"] = pod
+	}
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		data["kubernetes.namespace"] = ns
+	}
+	if node := os.Getenv("NODE_NAME"); node != "" {
+		data["kubernetes.node.name"] = node
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// DefaultMetadataProviders returns the standard set of providers used to
+// populate EnrollRequest.Metadata.Local: OS/kernel, host, cloud and
+// container/Kubernetes detection.
+func DefaultMetadataProviders() []MetadataProvider {
+	return []MetadataProvider{
+		OSMetadataProvider{},
+		HostMetadataProvider{},
+		CloudMetadataProvider{},
+		ContainerMetadataProvider{},
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMetadataRegistry_Collect_DropsErroringAndSlowProviders(t *testing.T) {
+	good := MetadataProviderFunc(func(ctx context.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"os.platform": "linux"}, nil
+	})
+
+	erroring := MetadataProviderFunc(func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, errors.New("boom")
+	})
+
+	// slow honors ctx cancellation (as the MetadataProvider contract
+	// requires) so it proves the registry's Timeout actually bounds it,
+	// rather than just racing a real sleep.
+	slow := MetadataProviderFunc(func(ctx context.Context) (map[string]interface{}, error) {
+		select {
+		case <-time.After(time.Second):
+			return map[string]interface{}{"cloud.provider": "aws"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+
+	registry := &MetadataRegistry{
+		Providers: []MetadataProvider{good, erroring, slow},
+		Timeout:   20 * time.Millisecond,
+	}
+
+	start := time.Now()
+	merged := registry.Collect(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Collect took %v, expected it to be bounded by the per-provider timeout, not the slow provider's full duration", elapsed)
+	}
+
+	if merged["os.platform"] != "linux" {
+		t.Fatalf("expected the good provider's data to be merged, got %+v", merged)
+	}
+	if _, ok := merged["cloud.provider"]; ok {
+		t.Fatalf("expected the slow provider to be dropped, got %+v", merged)
+	}
+}
+
+func TestMetadataRegistry_Collect_Sources(t *testing.T) {
+	os := MetadataProviderFunc(func(ctx context.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"os.platform": "linux"}, nil
+	})
+
+	registry := NewMetadataRegistry(os)
+
+	merged := registry.Collect(context.Background())
+
+	sources, ok := merged["meta.sources"].([]string)
+	if !ok || len(sources) != 1 {
+		t.Fatalf("expected meta.sources to record one contributing provider, got %+v", merged["meta.sources"])
+	}
+}
+
+// fakeEnrollClient is a clienter whose Send always returns a successful,
+// minimal enrollment response, for exercising ExecuteWithProviders without a
+// real transport.
+type fakeEnrollClient struct{}
+
+func (fakeEnrollClient) Send(method, path string, params url.Values, headers http.Header, body io.Reader) (*http.Response, error) {
+	resp := EnrollResponse{
+		Action:  "created",
+		Success: true,
+		Item: EnrollItemResponse{
+			ID:          "a4937110-e53e-11e9-934f-47a8e38a522c",
+			Type:        PermanentEnroll,
+			AccessToken: "ACCESS_TOKEN",
+		},
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(b)),
+	}, nil
+}
+
+func TestExecuteWithProviders_MergesWithoutClobberingCallerKeys(t *testing.T) {
+	cmd := NewEnrollCmd(fakeEnrollClient{})
+
+	r := &EnrollRequest{
+		EnrollmentToken: "token",
+		Type:            PermanentEnroll,
+		Metadata: Metadata{
+			Local: map[string]interface{}{"custom.key": "caller-value"},
+		},
+	}
+
+	provider := MetadataProviderFunc(func(ctx context.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"os.platform": "linux"}, nil
+	})
+
+	if _, err := cmd.ExecuteWithProviders(context.Background(), r, provider); err != nil {
+		t.Fatalf("ExecuteWithProviders: %v", err)
+	}
+
+	if r.Metadata.Local["custom.key"] != "caller-value" {
+		t.Fatalf("expected caller-set key to survive the merge, got %+v", r.Metadata.Local)
+	}
+	if r.Metadata.Local["os.platform"] != "linux" {
+		t.Fatalf("expected provider data to be merged in, got %+v", r.Metadata.Local)
+	}
+}
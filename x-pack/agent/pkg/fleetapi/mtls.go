@@ -0,0 +1,116 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// clientCertInstaller is implemented by clienter implementations that expose
+// their underlying transport, allowing a client certificate to be installed
+// for the duration of a single enroll call. SetClientCertificate returns a
+// restore func that puts the previous certificate (if any) back in place.
+type clientCertInstaller interface {
+	SetClientCertificate(cert *tls.Certificate) (restore func())
+}
+
+// fleetEndpointInfo reports the Fleet URL and CA fingerprint a clienter is
+// configured to talk to. ExecuteWithSignedToken requires its client to
+// implement this so a signed enrollment token's claims can be cross-checked
+// before any network I/O; a client that can't report its own endpoint can't
+// be verified against, so that's treated as a hard error rather than
+// silently skipping the check.
+type fleetEndpointInfo interface {
+	FleetURL() string
+	CASHA256() string
+}
+
+// ErrEnrollmentTokenMismatch is returned when a signed enrollment token's
+// claims don't match the Fleet server this EnrollCmd is configured to talk
+// to. Operators see this instead of a generic auth failure so they can
+// distinguish a misconfigured fleet URL from a stolen token being replayed
+// against a rogue server.
+var ErrEnrollmentTokenMismatch = errors.New("enrollment token does not match the configured fleet server")
+
+// ErrEnrollmentTokenExpired is returned when a signed enrollment token's exp
+// claim has already elapsed.
+var ErrEnrollmentTokenExpired = errors.New("enrollment token has expired")
+
+// SignedEnrollmentClaims is the payload carried by a signed enrollment token,
+// binding it to a specific Fleet server.
+type SignedEnrollmentClaims struct {
+	FleetURL string `json:"fleet_url"`
+	CASHA256 string `json:"ca_sha256"`
+	IssuedAt int64  `json:"issued_at"`
+	Exp      int64  `json:"exp"`
+}
+
+// verifySignedEnrollmentToken parses a token of the form
+// "<base64url-payload>.<base64url-signature>", verifies the signature with
+// pub, and decodes the payload into its claims.
+func verifySignedEnrollmentToken(token string, pub ed25519.PublicKey) (*SignedEnrollmentClaims, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid signed enrollment token format")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to decode enrollment token payload")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to decode enrollment token signature")
+	}
+
+	if !ed25519.Verify(pub, payload, sig) {
+		return nil, errors.New("enrollment token signature verification failed")
+	}
+
+	var claims SignedEnrollmentClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "fail to decode enrollment token claims")
+	}
+
+	return &claims, nil
+}
+
+// ExecuteWithSignedToken verifies a signed enrollment token against pub and
+// cross-checks its claims against the Fleet server this EnrollCmd is
+// configured to talk to, failing with ErrEnrollmentTokenMismatch or
+// ErrEnrollmentTokenExpired before any network I/O. On success, it uses the
+// token as r.EnrollmentToken and proceeds as ExecuteContext.
+func (e *EnrollCmd) ExecuteWithSignedToken(ctx context.Context, r *EnrollRequest, token string, pub ed25519.PublicKey) (*EnrollResponse, error) {
+	claims, err := verifySignedEnrollmentToken(token, pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Exp > 0 && time.Now().Unix() > claims.Exp {
+		return nil, ErrEnrollmentTokenExpired
+	}
+
+	info, ok := e.client.(fleetEndpointInfo)
+	if !ok {
+		return nil, errors.New("client does not support fleet endpoint verification")
+	}
+
+	if claims.FleetURL != info.FleetURL() || claims.CASHA256 != info.CASHA256() {
+		return nil, ErrEnrollmentTokenMismatch
+	}
+
+	r.EnrollmentToken = token
+
+	return e.ExecuteContext(ctx, r)
+}
@@ -0,0 +1,163 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func signEnrollmentToken(t *testing.T, priv ed25519.PrivateKey, claims SignedEnrollmentClaims) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+
+	sig := ed25519.Sign(priv, payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifySignedEnrollmentToken_Valid(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	claims := SignedEnrollmentClaims{
+		FleetURL: "https://fleet.example.com",
+		CASHA256: "deadbeef",
+		IssuedAt: time.Now().Unix(),
+		Exp:      time.Now().Add(time.Hour).Unix(),
+	}
+	token := signEnrollmentToken(t, priv, claims)
+
+	got, err := verifySignedEnrollmentToken(token, pub)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if got.FleetURL != claims.FleetURL || got.CASHA256 != claims.CASHA256 {
+		t.Fatalf("unexpected claims: %+v", got)
+	}
+}
+
+func TestVerifySignedEnrollmentToken_BadSignature(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	token := signEnrollmentToken(t, otherPriv, SignedEnrollmentClaims{FleetURL: "https://fleet.example.com"})
+
+	if _, err := verifySignedEnrollmentToken(token, pub); err == nil {
+		t.Fatal("expected signature verification to fail for a token signed by a different key")
+	}
+}
+
+func TestVerifySignedEnrollmentToken_MalformedToken(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	if _, err := verifySignedEnrollmentToken("not-a-token", pub); err == nil {
+		t.Fatal("expected an error for a token with no payload.signature separator")
+	}
+}
+
+// fakeEndpointClient implements clienter and fleetEndpointInfo so
+// ExecuteWithSignedToken's cross-check can be exercised without a real
+// transport; Send fails the test if it's ever reached, since a mismatch or
+// expiry must be caught before any network I/O.
+type fakeEndpointClient struct {
+	fleetURL string
+	caSHA256 string
+}
+
+func (f *fakeEndpointClient) Send(method, path string, params url.Values, headers http.Header, body io.Reader) (*http.Response, error) {
+	return nil, errors.New("fakeEndpointClient.Send should not be called")
+}
+
+func (f *fakeEndpointClient) FleetURL() string { return f.fleetURL }
+func (f *fakeEndpointClient) CASHA256() string { return f.caSHA256 }
+
+// noEndpointInfoClient implements clienter only, modeling a client that
+// cannot report its own Fleet endpoint.
+type noEndpointInfoClient struct{}
+
+func (noEndpointInfoClient) Send(method, path string, params url.Values, headers http.Header, body io.Reader) (*http.Response, error) {
+	return nil, errors.New("noEndpointInfoClient.Send should not be called")
+}
+
+func TestExecuteWithSignedToken_Mismatch(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	token := signEnrollmentToken(t, priv, SignedEnrollmentClaims{
+		FleetURL: "https://attacker.example.com",
+		CASHA256: "deadbeef",
+		Exp:      time.Now().Add(time.Hour).Unix(),
+	})
+
+	cmd := NewEnrollCmd(&fakeEndpointClient{fleetURL: "https://fleet.example.com", caSHA256: "deadbeef"})
+
+	_, err = cmd.ExecuteWithSignedToken(context.Background(), &EnrollRequest{Type: PermanentEnroll}, token, pub)
+	if !errors.Is(err, ErrEnrollmentTokenMismatch) {
+		t.Fatalf("expected ErrEnrollmentTokenMismatch, got %v", err)
+	}
+}
+
+func TestExecuteWithSignedToken_Expired(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	token := signEnrollmentToken(t, priv, SignedEnrollmentClaims{
+		FleetURL: "https://fleet.example.com",
+		Exp:      time.Now().Add(-time.Hour).Unix(),
+	})
+
+	cmd := NewEnrollCmd(&fakeEndpointClient{fleetURL: "https://fleet.example.com"})
+
+	_, err = cmd.ExecuteWithSignedToken(context.Background(), &EnrollRequest{Type: PermanentEnroll}, token, pub)
+	if !errors.Is(err, ErrEnrollmentTokenExpired) {
+		t.Fatalf("expected ErrEnrollmentTokenExpired, got %v", err)
+	}
+}
+
+func TestExecuteWithSignedToken_RequiresFleetEndpointInfo(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	token := signEnrollmentToken(t, priv, SignedEnrollmentClaims{
+		FleetURL: "https://fleet.example.com",
+		Exp:      time.Now().Add(time.Hour).Unix(),
+	})
+
+	cmd := NewEnrollCmd(noEndpointInfoClient{})
+
+	if _, err := cmd.ExecuteWithSignedToken(context.Background(), &EnrollRequest{Type: PermanentEnroll}, token, pub); err == nil {
+		t.Fatal("expected an error when the client cannot report its fleet endpoint")
+	}
+}
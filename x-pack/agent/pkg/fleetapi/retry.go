@@ -0,0 +1,120 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how EnrollCmd retries a failed enrollment attempt
+// against a Fleet server that is temporarily unavailable or rate-limiting
+// enrollment requests.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+
+	// InitialBackoff is the wait duration before the second attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the computed backoff, including any Retry-After override.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the previous backoff after every failed attempt.
+	Multiplier float64
+
+	// Jitter is the fraction (0..1) of random variance added to each backoff.
+	Jitter float64
+
+	// OnRetry, when set, is called with the failed attempt's error and the
+	// computed wait duration before every retry. Useful for progress logging.
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// DefaultRetryPolicy returns sane defaults for retrying enrollment against a
+// Fleet server that is rolling or rate-limiting.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// retryableStatusCodes are the Fleet server responses worth retrying; 400,
+// 401, 403 and 409 (already enrolled) are configuration or credential
+// problems that won't be fixed by waiting, so they are deliberately excluded.
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// isRetryable reports whether err represents a condition worth retrying.
+// Only a StatusError with a retryable status code, or a transportError (the
+// call never reached Fleet at all), are retried. Validation, encoding and
+// decoding errors are deterministic and fail fast instead.
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatusCodes[statusErr.StatusCode]
+	}
+
+	var transportErr *transportError
+	return errors.As(err, &transportErr)
+}
+
+// parseRetryAfter parses the Retry-After header in either its seconds or
+// HTTP-date form, as described in RFC 7231 section 7.1.3.
+func parseRetryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
+
+// nextBackoff computes the exponential backoff for the given attempt number
+// (1-indexed, the attempt that just failed), applying policy.Jitter.
+func nextBackoff(policy RetryPolicy, attempt int) time.Duration {
+	d := float64(policy.InitialBackoff) * math.Pow(policy.Multiplier, float64(attempt-1))
+
+	if policy.Jitter > 0 {
+		d += d * policy.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	if d < 0 {
+		d = 0
+	}
+
+	wait := time.Duration(d)
+	if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+		wait = policy.MaxBackoff
+	}
+
+	return wait
+}
@@ -0,0 +1,89 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fleetapi
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"transport error", &transportError{Err: errors.New("connection refused")}, true},
+		{"retryable status", &StatusError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("unavailable")}, true},
+		{"non-retryable status", &StatusError{StatusCode: http.StatusUnauthorized, Err: errors.New("unauthorized")}, false},
+		{"conflict status (already enrolled)", &StatusError{StatusCode: http.StatusConflict, Err: errors.New("already enrolled")}, false},
+		{"plain validation error", errors.New("missing enrollment token"), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryable(c.err); got != c.want {
+				t.Fatalf("isRetryable(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter_Seconds(t *testing.T) {
+	d, ok := parseRetryAfter("5")
+	if !ok || d != 5*time.Second {
+		t.Fatalf("got (%v, %v), want (5s, true)", d, ok)
+	}
+}
+
+func TestParseRetryAfter_HTTPDate(t *testing.T) {
+	future := time.Now().Add(10 * time.Second).UTC()
+
+	d, ok := parseRetryAfter(future.Format(http.TimeFormat))
+	if !ok {
+		t.Fatal("expected a duration to be parsed from an HTTP-date header")
+	}
+	if d <= 0 || d > 11*time.Second {
+		t.Fatalf("unexpected duration: %v", d)
+	}
+}
+
+func TestParseRetryAfter_Invalid(t *testing.T) {
+	if _, ok := parseRetryAfter("not-a-valid-value"); ok {
+		t.Fatal("expected parseRetryAfter to reject an invalid header")
+	}
+}
+
+func TestNextBackoff_Exponential(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: time.Second,
+		Multiplier:     2,
+		MaxBackoff:     time.Minute,
+	}
+
+	if got := nextBackoff(policy, 1); got != time.Second {
+		t.Fatalf("attempt 1: got %v, want 1s", got)
+	}
+	if got := nextBackoff(policy, 2); got != 2*time.Second {
+		t.Fatalf("attempt 2: got %v, want 2s", got)
+	}
+	if got := nextBackoff(policy, 3); got != 4*time.Second {
+		t.Fatalf("attempt 3: got %v, want 4s", got)
+	}
+}
+
+func TestNextBackoff_CapsAtMaxBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: time.Second,
+		Multiplier:     10,
+		MaxBackoff:     5 * time.Second,
+	}
+
+	if got := nextBackoff(policy, 5); got != 5*time.Second {
+		t.Fatalf("expected backoff to cap at MaxBackoff, got %v", got)
+	}
+}